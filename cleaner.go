@@ -2,109 +2,34 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"sort"
+	"os/signal"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/TinyClayMan/DockerHubCleaner/mirror"
+	"github.com/TinyClayMan/DockerHubCleaner/policy"
+	"github.com/TinyClayMan/DockerHubCleaner/progress"
+	"github.com/TinyClayMan/DockerHubCleaner/ratelimit"
+	"github.com/TinyClayMan/DockerHubCleaner/registry"
 )
 
-const dockerHubAPI = "https://hub.docker.com/v2"
+// defaultConcurrency is how many deletion workers run when CONCURRENCY is unset.
+const defaultConcurrency = 4
 
-// Struct for image tag response
-type Tag struct {
-	Name        string    `json:"name"`
-	LastUpdated time.Time `json:"last_updated"`
-	FullSize    int64     `json:"full_size"` // size in bytes
-}
-
-type TagsResponse struct {
-	Results []Tag `json:"results"`
-	Next    string `json:"next"`
-}
-
-// Get all tags from the repository
-func getTags(user, repo, token string) ([]Tag, error) {
-	var tags []Tag
-	urlStr := fmt.Sprintf("%s/repositories/%s/%s/tags?page_size=100", dockerHubAPI, user, repo)
-
-	client := &http.Client{}
-	for urlStr != "" {
-		req, _ := http.NewRequest("GET", urlStr, nil)
-		req.Header.Set("Authorization", "JWT "+token)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("failed to fetch tags: %s", resp.Status)
-		}
-
-		var tr TagsResponse
-		if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
-			return nil, err
-		}
-
-		tags = append(tags, tr.Results...)
-		urlStr = tr.Next
-	}
-	return tags, nil
-}
-
-// Delete tag
-func deleteTag(user, repo, tag, token string) error {
-	urlStr := fmt.Sprintf("%s/repositories/%s/%s/tags/%s/", dockerHubAPI, user, repo, tag)
-	client := &http.Client{}
-	req, _ := http.NewRequest("DELETE", urlStr, nil)
-	req.Header.Set("Authorization", "JWT "+token)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 204 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete %s: %s", tag, string(body))
-	}
-	return nil
-}
-
-// Get JWT token
-func login(user, password string) (string, error) {
-	data := url.Values{}
-	data.Set("username", user)
-	data.Set("password", password)
-
-	resp, err := http.PostForm(dockerHubAPI+"/users/login/", data)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("login failed: %s", resp.Status)
-	}
+// dockerHubRegistryURL is where Docker Hub serves image content (manifests
+// and blobs) over the standard v2 API, as opposed to hub.docker.com's
+// proprietary API used for login/listing/deleting tags.
+const dockerHubRegistryURL = "https://registry-1.docker.io"
 
-	var result map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-	return result["token"], nil
-}
-
-// Compute the total volume occupied by all tags present in the repository
-func sumSize(tags []Tag) int64 {
+// sumPolicySize totals the size of a tag set.
+func sumPolicySize(tags []policy.Tag) int64 {
 	var total int64
 	for _, t := range tags {
 		total += t.FullSize
@@ -140,9 +65,22 @@ func main() {
 	user := os.Getenv("DOCKER_USERNAME")
 	password := os.Getenv("DOCKER_PASSWORD")
 	repo := os.Getenv("DOCKER_REPOSITORY")
+	registryURL := os.Getenv("REGISTRY_URL")
 	keepCountStr := os.Getenv("KEEP_COUNT")
 	maxSizeStr := os.Getenv("MAX_SIZE_MB")
 	skipFile := os.Getenv("SKIP_TAGS_FILE")
+	keepSemver := os.Getenv("KEEP_SEMVER")
+	includePattern := os.Getenv("INCLUDE_PATTERN")
+	excludePattern := os.Getenv("EXCLUDE_PATTERN")
+	includeRegex := os.Getenv("REGEX_INCLUDE_PATTERN")
+	excludeRegex := os.Getenv("REGEX_EXCLUDE_PATTERN")
+	dryRun := os.Getenv("DRY_RUN") == "1"
+	concurrencyStr := os.Getenv("CONCURRENCY")
+	rateLimitStr := os.Getenv("RATE_LIMIT_PER_SEC")
+	mirrorRegistry := os.Getenv("MIRROR_REGISTRY")
+	mirrorPrefix := os.Getenv("MIRROR_NAMESPACE_PREFIX")
+	mirrorUser := os.Getenv("MIRROR_USERNAME")
+	mirrorPassword := os.Getenv("MIRROR_PASSWORD")
 
 	if user == "" || password == "" || repo == "" {
 		log.Fatal("Missing required environment variables: DOCKER_USERNAME, DOCKER_PASSWORD, DOCKER_REPOSITORY")
@@ -167,6 +105,33 @@ func main() {
 		}
 	}
 
+	switch keepSemver {
+	case "", policy.KeepSemverLatestMajor, policy.KeepSemverLatestMinor:
+	default:
+		log.Fatalf("Invalid KEEP_SEMVER value: %s (want %q or %q)", keepSemver, policy.KeepSemverLatestMajor, policy.KeepSemverLatestMinor)
+	}
+
+	concurrency := defaultConcurrency
+	if concurrencyStr != "" {
+		concurrency, err = strconv.Atoi(concurrencyStr)
+		if err != nil || concurrency < 1 {
+			log.Fatalf("Invalid CONCURRENCY value: %s", concurrencyStr)
+		}
+	}
+
+	var rateLimitPerSec float64
+	if rateLimitStr != "" {
+		rateLimitPerSec, err = strconv.ParseFloat(rateLimitStr, 64)
+		if err != nil || rateLimitPerSec <= 0 {
+			log.Fatalf("Invalid RATE_LIMIT_PER_SEC value: %s", rateLimitStr)
+		}
+	}
+
+	filter, err := policy.NewFilter(includePattern, excludePattern, includeRegex, excludeRegex)
+	if err != nil {
+		log.Fatalf("Invalid tag filter pattern: %v", err)
+	}
+
 	// Load skip list if provided
 	skipTags := make(map[string]struct{})
 	if skipFile != "" {
@@ -177,58 +142,175 @@ func main() {
 		fmt.Printf("Loaded %d protected tags from %s\n", len(skipTags), skipFile)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "\nReceived interrupt, cancelling in-flight request and aborting...")
+		cancel()
+	}()
+
+	var limiter *ratelimit.Limiter
+	if rateLimitPerSec > 0 {
+		limiter = ratelimit.New(rateLimitPerSec)
+	}
+	client := registry.New(registryURL, limiter)
+
 	// Authenticate
-	token, err := login(user, password)
-	if err != nil {
+	if err := client.Login(ctx, user, password); err != nil {
 		log.Fatal("Login failed:", err)
 	}
 
+	// Docker Hub repository paths are "user/repo"; for other registries
+	// DOCKER_REPOSITORY is expected to already be a full repository path.
+	repoPath := repo
+	if registryURL == "" {
+		repoPath = user + "/" + repo
+	}
+
+	var mirrorer *mirror.Mirror
+	if mirrorRegistry != "" {
+		sourceContentURL := registryURL
+		if sourceContentURL == "" {
+			sourceContentURL = dockerHubRegistryURL
+		}
+		mirrorer = mirror.New(sourceContentURL, mirrorRegistry, mirrorPrefix)
+		if err := mirrorer.Login(ctx, user, password); err != nil {
+			log.Fatal("Mirror source login failed:", err)
+		}
+		// The mirror target is typically a different service (an archive
+		// registry) than the source, so it gets its own credentials,
+		// falling back to the source's if none are set.
+		targetUser, targetPassword := mirrorUser, mirrorPassword
+		if targetUser == "" {
+			targetUser, targetPassword = user, password
+		}
+		if err := mirrorer.LoginTarget(ctx, targetUser, targetPassword); err != nil {
+			log.Fatal("Mirror target login failed:", err)
+		}
+	}
+
 	// Fetch tags
-	tags, err := getTags(user, repo, token)
+	all, err := client.ListTags(ctx, repoPath)
 	if err != nil {
 		log.Fatal("Failed to get tags:", err)
 	}
 
-	// Sort by last updated (newest first)
-	sort.Slice(tags, func(i, j int) bool {
-		return tags[i].LastUpdated.After(tags[j].LastUpdated)
-	})
+	filtered := filter.Apply(all)
 
-	// Apply KEEP_COUNT if set
-	if keepCount >= 0 && len(tags) > keepCount {
-		toDelete := tags[keepCount:]
-		for _, t := range toDelete {
-			if _, ok := skipTags[t.Name]; ok {
-				fmt.Println("Skipping protected tag:", t.Name)
-				continue
-			}
-			fmt.Println("Deleting (exceeds count):", t.Name)
-			if err := deleteTag(user, repo, t.Name, token); err != nil {
-				log.Println("Error deleting:", t.Name, err)
+	p := &policy.Policy{
+		KeepCount:  keepCount,
+		MaxSizeMB:  maxSizeMB,
+		KeepSemver: keepSemver,
+		SkipTags:   skipTags,
+	}
+
+	// Tags excluded by the filter pipeline are left untouched; they still
+	// count toward the repository's remaining size/count in the summary.
+	var remaining int
+	var remainingSize int64
+	if excluded := len(all) - len(filtered); excluded > 0 {
+		remaining += excluded
+		remainingSize += sumPolicySize(all) - sumPolicySize(filtered)
+	}
+
+	decisions := p.Evaluate(filtered)
+	bar := progress.New(len(decisions), os.Stderr)
+
+	var remainingAtomic int64 = int64(remaining)
+	var remainingSizeAtomic int64 = remainingSize
+
+	var errMu sync.Mutex
+	var deleteErrors []string
+
+	jobs := make(chan policy.Decision)
+	var producedAll atomic.Bool
+	var sentCount int64
+
+	go func() {
+		defer close(jobs)
+		for _, d := range decisions {
+			select {
+			case jobs <- d:
+				atomic.AddInt64(&sentCount, 1)
+			case <-ctx.Done():
+				return
 			}
 		}
-		tags = tags[:keepCount]
-	}
-
-	// Apply MAX_SIZE_MB if set
-	if maxSizeMB > 0 {
-		for sumSize(tags) > maxSizeMB*1024*1024 && len(tags) > 0 {
-			oldest := tags[len(tags)-1]
-			if _, ok := skipTags[oldest.Name]; ok {
-				fmt.Println("Skipping protected tag:", oldest.Name)
-				// if skipping, just move to next oldest
-				tags = tags[:len(tags)-1]
-				continue
-			}
-			fmt.Printf("Deleting (exceeds size, total=%.2fMB): %s\n",
-				float64(sumSize(tags))/(1024*1024), oldest.Name)
-			if err := deleteTag(user, repo, oldest.Name, token); err != nil {
-				log.Println("Error deleting:", oldest.Name, err)
+		producedAll.Store(true)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				if !d.Delete {
+					atomic.AddInt64(&remainingAtomic, 1)
+					atomic.AddInt64(&remainingSizeAtomic, d.Tag.FullSize)
+					bar.Advance(0)
+					continue
+				}
+
+				if dryRun {
+					fmt.Printf("[DRY RUN] Would delete (%s): %s\n", d.Reason, d.Tag.Name)
+					bar.Advance(d.Tag.FullSize)
+					continue
+				}
+
+				if mirrorer != nil {
+					if err := mirrorer.Mirror(ctx, repoPath, d.Tag.Name); err != nil {
+						errMu.Lock()
+						deleteErrors = append(deleteErrors, fmt.Sprintf("%s: mirror failed, not deleted: %v", d.Tag.Name, err))
+						errMu.Unlock()
+						atomic.AddInt64(&remainingAtomic, 1)
+						atomic.AddInt64(&remainingSizeAtomic, d.Tag.FullSize)
+						bar.Advance(0)
+						continue
+					}
+				}
+
+				fmt.Printf("Deleting (%s): %s\n", d.Reason, d.Tag.Name)
+				if err := client.DeleteTag(ctx, repoPath, d.Tag.Name); err != nil {
+					errMu.Lock()
+					deleteErrors = append(deleteErrors, fmt.Sprintf("%s: %v", d.Tag.Name, err))
+					errMu.Unlock()
+					atomic.AddInt64(&remainingAtomic, 1)
+					atomic.AddInt64(&remainingSizeAtomic, d.Tag.FullSize)
+					bar.Advance(0)
+					continue
+				}
+				bar.Advance(d.Tag.FullSize)
 			}
-			tags = tags[:len(tags)-1]
+		}()
+	}
+	wg.Wait()
+	bar.Finish()
+
+	remaining = int(atomic.LoadInt64(&remainingAtomic))
+	remainingSize = atomic.LoadInt64(&remainingSizeAtomic)
+
+	if len(deleteErrors) > 0 {
+		fmt.Printf("Encountered %d error(s) while deleting:\n", len(deleteErrors))
+		for _, e := range deleteErrors {
+			fmt.Println("  -", e)
+		}
+	}
+
+	if !producedAll.Load() {
+		for _, d := range decisions[sentCount:] {
+			remaining++
+			remainingSize += d.Tag.FullSize
 		}
+		fmt.Printf("Aborted. Remaining images: %d, total size: %.2f MB\n",
+			remaining, float64(remainingSize)/(1024*1024))
+		os.Exit(1)
 	}
 
 	fmt.Printf("Cleanup complete. Remaining images: %d, total size: %.2f MB\n",
-		len(tags), float64(sumSize(tags))/(1024*1024))
+		remaining, float64(remainingSize)/(1024*1024))
 }
@@ -0,0 +1,141 @@
+// Package mirror implements DockerHubCleaner's mirror-before-delete
+// workflow: copying a tag's manifest and every blob it references from a
+// source v2 registry to a target one before the tag is deleted, so cleanup
+// doubles as an archive step rather than being purely destructive.
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/TinyClayMan/DockerHubCleaner/registry"
+)
+
+// manifestRef is the shape shared by a manifest's "config"/"layers" entries
+// and a manifest list's "manifests" entries: just enough to find a digest.
+type manifestRef struct {
+	Digest string `json:"digest"`
+}
+
+// manifest covers both a single-platform v2/OCI manifest (Config + Layers)
+// and a multi-platform manifest list/OCI index (Manifests), since mirroring
+// needs to walk either shape to find every blob and sub-manifest.
+type manifest struct {
+	Config    *manifestRef  `json:"config"`
+	Layers    []manifestRef `json:"layers"`
+	Manifests []manifestRef `json:"manifests"`
+}
+
+// Mirror copies tags from a source v2 registry to a target v2 registry
+// before DockerHubCleaner deletes them.
+type Mirror struct {
+	source   *registry.V2Client
+	target   *registry.V2Client
+	prefix   string
+	sameHost bool // cross-repository blob mounts only work within a single registry
+}
+
+// New returns a Mirror pulling from sourceURL and pushing to targetURL. If
+// prefix is non-empty, target repository names are written under it (e.g.
+// prefix "archive" turns repo "alice/app" into "archive/alice/app").
+func New(sourceURL, targetURL, prefix string) *Mirror {
+	return &Mirror{
+		source:   registry.NewV2Client(sourceURL, nil),
+		target:   registry.NewV2Client(targetURL, nil),
+		prefix:   strings.Trim(prefix, "/"),
+		sameHost: strings.TrimRight(sourceURL, "/") == strings.TrimRight(targetURL, "/"),
+	}
+}
+
+// Login authenticates the source v2 client with sourceUser/sourcePassword.
+// Call it, then LoginTarget, before the first Mirror.
+func (m *Mirror) Login(ctx context.Context, sourceUser, sourcePassword string) error {
+	return m.source.Login(ctx, sourceUser, sourcePassword)
+}
+
+// LoginTarget authenticates the target v2 client. The target registry is
+// typically a different service than the source, so it gets its own
+// credentials rather than reusing the source's.
+func (m *Mirror) LoginTarget(ctx context.Context, targetUser, targetPassword string) error {
+	return m.target.Login(ctx, targetUser, targetPassword)
+}
+
+func (m *Mirror) targetRepo(repo string) string {
+	if m.prefix == "" {
+		return repo
+	}
+	return m.prefix + "/" + repo
+}
+
+// Mirror copies repo:tag, and recursively every manifest a manifest
+// list/OCI index references, from the source registry to the target
+// registry under the same tag name (subject to Mirror's namespace prefix).
+// Blobs already present at the target are not uploaded again, so repeated
+// runs are cheap; manifests are always fetched and pushed.
+func (m *Mirror) Mirror(ctx context.Context, repo, tag string) error {
+	return m.mirrorManifest(ctx, repo, m.targetRepo(repo), tag)
+}
+
+func (m *Mirror) mirrorManifest(ctx context.Context, srcRepo, dstRepo, ref string) error {
+	body, contentType, err := m.source.GetManifest(ctx, srcRepo, ref)
+	if err != nil {
+		return fmt.Errorf("fetch manifest %s:%s: %w", srcRepo, ref, err)
+	}
+
+	var man manifest
+	if err := json.Unmarshal(body, &man); err != nil {
+		return fmt.Errorf("parse manifest %s:%s: %w", srcRepo, ref, err)
+	}
+
+	for _, sub := range man.Manifests {
+		if err := m.mirrorManifest(ctx, srcRepo, dstRepo, sub.Digest); err != nil {
+			return err
+		}
+	}
+
+	var blobs []string
+	if man.Config != nil {
+		blobs = append(blobs, man.Config.Digest)
+	}
+	for _, l := range man.Layers {
+		blobs = append(blobs, l.Digest)
+	}
+	for _, digest := range blobs {
+		if err := m.mirrorBlob(ctx, srcRepo, dstRepo, digest); err != nil {
+			return fmt.Errorf("mirror blob %s: %w", digest, err)
+		}
+	}
+
+	if err := m.target.PutManifest(ctx, dstRepo, ref, contentType, body); err != nil {
+		return fmt.Errorf("push manifest %s:%s: %w", dstRepo, ref, err)
+	}
+	return nil
+}
+
+func (m *Mirror) mirrorBlob(ctx context.Context, srcRepo, dstRepo, digest string) error {
+	exists, err := m.target.HasBlob(ctx, dstRepo, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	// Cross-repository mount only works within a single registry; attempting
+	// it against a different target host would always 404.
+	if m.sameHost {
+		if mounted, err := m.target.MountBlob(ctx, dstRepo, digest, srcRepo); err == nil && mounted {
+			return nil
+		}
+	}
+
+	r, size, err := m.source.GetBlob(ctx, srcRepo, digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return m.target.PushBlob(ctx, dstRepo, digest, r, size)
+}
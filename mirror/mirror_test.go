@@ -0,0 +1,17 @@
+package mirror
+
+import "testing"
+
+func TestTargetRepoWithoutPrefix(t *testing.T) {
+	m := New("https://source.example.com", "https://target.example.com", "")
+	if got := m.targetRepo("alice/app"); got != "alice/app" {
+		t.Errorf("targetRepo() = %q, want %q", got, "alice/app")
+	}
+}
+
+func TestTargetRepoWithPrefix(t *testing.T) {
+	m := New("https://source.example.com", "https://target.example.com", "/archive/")
+	if got := m.targetRepo("alice/app"); got != "archive/alice/app" {
+		t.Errorf("targetRepo() = %q, want %q", got, "archive/alice/app")
+	}
+}
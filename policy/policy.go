@@ -0,0 +1,307 @@
+// Package policy decides which repository tags should be deleted during a
+// cleanup run. It has no knowledge of HTTP or any particular registry: it
+// consumes a plain slice of Tag values and returns Decisions, so the rules
+// here can be unit tested without a live Docker Hub (or any other registry)
+// client.
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag is the subset of registry tag metadata the policy engine needs to make
+// retention decisions.
+type Tag struct {
+	Name        string
+	LastUpdated time.Time
+	FullSize    int64 // bytes
+}
+
+// Decision records what the policy engine decided to do with a single tag,
+// and why, so callers can log or dry-run without re-deriving the reason.
+type Decision struct {
+	Tag    Tag
+	Delete bool
+	Reason string
+}
+
+// Filter narrows a tag set down to the ones eligible for retention policies
+// at all, by matching tag names against include/exclude glob and regex
+// patterns. This mirrors the name-filter pipeline used by Harbor's Docker Hub
+// replication adapter: include patterns are checked first, then exclude
+// patterns, and only tags that survive both reach the count/size/semver
+// policy below.
+type Filter struct {
+	includeGlobs []string
+	excludeGlobs []string
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+}
+
+// NewFilter builds a Filter from comma-separated glob lists and comma-separated
+// regex lists. Any of the four arguments may be empty to skip that stage.
+func NewFilter(includePattern, excludePattern, includeRegexPattern, excludeRegexPattern string) (*Filter, error) {
+	f := &Filter{
+		includeGlobs: splitPatterns(includePattern),
+		excludeGlobs: splitPatterns(excludePattern),
+	}
+
+	var err error
+	if f.includeRegex, err = compilePatterns(splitPatterns(includeRegexPattern)); err != nil {
+		return nil, fmt.Errorf("invalid include regex: %w", err)
+	}
+	if f.excludeRegex, err = compilePatterns(splitPatterns(excludeRegexPattern)); err != nil {
+		return nil, fmt.Errorf("invalid exclude regex: %w", err)
+	}
+	return f, nil
+}
+
+func splitPatterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// Matches reports whether name passes the include stage and is not rejected
+// by the exclude stage. An empty include set means "include everything".
+func (f *Filter) Matches(name string) bool {
+	if f == nil {
+		return true
+	}
+	if !f.matchesAny(name, f.includeGlobs, f.includeRegex, true) {
+		return false
+	}
+	return !f.matchesAny(name, f.excludeGlobs, f.excludeRegex, false)
+}
+
+// matchesAny returns whether name matches any of the given globs/regexes. If
+// both pattern lists are empty, defaultWhenEmpty is returned (true for an
+// include stage with nothing configured, false for an exclude stage).
+func (f *Filter) matchesAny(name string, globs []string, regexes []*regexp.Regexp, defaultWhenEmpty bool) bool {
+	if len(globs) == 0 && len(regexes) == 0 {
+		return defaultWhenEmpty
+	}
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, name); ok {
+			return true
+		}
+	}
+	for _, re := range regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply filters tags down to those that pass the include/exclude pipeline.
+func (f *Filter) Apply(tags []Tag) []Tag {
+	if f == nil {
+		return tags
+	}
+	kept := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		if f.Matches(t.Name) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// Semver grouping modes for KEEP_SEMVER.
+const (
+	KeepSemverNone        = ""
+	KeepSemverLatestMajor = "latest-per-major"
+	KeepSemverLatestMinor = "latest-per-minor"
+)
+
+// Policy is the set of retention rules applied to a (pre-filtered) tag set.
+type Policy struct {
+	// KeepCount keeps the newest KeepCount tags by LastUpdated. -1 disables it.
+	KeepCount int
+	// MaxSizeMB deletes the oldest tags until the remaining set fits under
+	// this budget. <= 0 disables it.
+	MaxSizeMB int64
+	// KeepSemver is one of the KeepSemver* constants above.
+	KeepSemver string
+	// SkipTags are never deleted regardless of the other rules.
+	SkipTags map[string]struct{}
+}
+
+// Evaluate applies the configured rules to tags, already assumed to have
+// passed any Filter, and returns a Decision per tag. Tags preserved by
+// KeepSemver are removed from consideration before KeepCount/MaxSizeMB run,
+// so semver-grouped tags don't count against the plain count/size budget.
+func (p *Policy) Evaluate(tags []Tag) []Decision {
+	sorted := make([]Tag, len(tags))
+	copy(sorted, tags)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LastUpdated.After(sorted[j].LastUpdated)
+	})
+
+	decisions := make(map[string]*Decision, len(sorted))
+	for i := range sorted {
+		decisions[sorted[i].Name] = &Decision{Tag: sorted[i], Delete: false}
+	}
+
+	remaining := sorted
+	if p.KeepSemver != KeepSemverNone {
+		remaining = p.applySemver(sorted, decisions)
+	}
+
+	if p.KeepCount >= 0 && len(remaining) > p.KeepCount {
+		toDelete := remaining[p.KeepCount:]
+		remaining = remaining[:p.KeepCount]
+		for _, t := range toDelete {
+			p.markDelete(decisions, t, "exceeds KEEP_COUNT")
+		}
+	}
+
+	if p.MaxSizeMB > 0 {
+		budget := p.MaxSizeMB * 1024 * 1024
+		for sumSize(remaining) > budget && len(remaining) > 0 {
+			oldest := remaining[len(remaining)-1]
+			remaining = remaining[:len(remaining)-1]
+			p.markDelete(decisions, oldest, "exceeds MAX_SIZE_MB")
+		}
+	}
+
+	out := make([]Decision, 0, len(sorted))
+	for _, t := range sorted {
+		out = append(out, *decisions[t.Name])
+	}
+	return out
+}
+
+// markDelete marks a tag for deletion unless it is in the skip list, in
+// which case it keeps its Decision as-is but records the reason it was
+// spared.
+func (p *Policy) markDelete(decisions map[string]*Decision, t Tag, reason string) {
+	if _, skip := p.SkipTags[t.Name]; skip {
+		decisions[t.Name].Reason = "protected tag, skipped"
+		return
+	}
+	decisions[t.Name].Delete = true
+	decisions[t.Name].Reason = reason
+}
+
+// applySemver groups semver-parseable tags by major (or major.minor) version,
+// keeps the newest tag in each group, and marks the rest as superseded.
+// Only non-semver tags are returned for further (count/size) policy
+// evaluation; the kept tag of each group is not subject to it.
+func (p *Policy) applySemver(tags []Tag, decisions map[string]*Decision) []Tag {
+	groups := make(map[string][]Tag)
+	var order []string
+	var rest []Tag
+
+	for _, t := range tags {
+		v, ok := parseSemver(t.Name)
+		if !ok {
+			rest = append(rest, t)
+			continue
+		}
+		key := fmt.Sprintf("%d", v.major)
+		if p.KeepSemver == KeepSemverLatestMinor {
+			key = fmt.Sprintf("%d.%d", v.major, v.minor)
+		}
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], t)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		sort.Slice(group, func(i, j int) bool {
+			return compareSemver(group[i].Name, group[j].Name) > 0
+		})
+		newest := group[0]
+		for _, t := range group[1:] {
+			p.markDelete(decisions, t, fmt.Sprintf("superseded by %s (KEEP_SEMVER=%s)", newest.Name, p.KeepSemver))
+		}
+	}
+
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].LastUpdated.After(rest[j].LastUpdated)
+	})
+	return rest
+}
+
+func sumSize(tags []Tag) int64 {
+	var total int64
+	for _, t := range tags {
+		total += t.FullSize
+	}
+	return total
+}
+
+// semver is a minimal parsed representation; pre-release/build metadata is
+// not tracked since tag grouping only needs major/minor/patch.
+type semver struct {
+	major, minor, patch int
+}
+
+var semverRE = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// parseSemver extracts a semver-ish major[.minor[.patch]] from a tag name,
+// tolerating a leading "v" and a missing minor/patch (e.g. "v2" or "1.4").
+// It returns ok=false for tags that don't start with a numeric version.
+func parseSemver(name string) (semver, bool) {
+	m := semverRE.FindStringSubmatch(name)
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	v.major, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		v.minor, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	return v, true
+}
+
+// compareSemver returns a positive number if tag a is newer than tag b,
+// negative if older, 0 if equal. Both names must already be known to parse.
+func compareSemver(a, b string) int {
+	va, _ := parseSemver(a)
+	vb, _ := parseSemver(b)
+	switch {
+	case va.major != vb.major:
+		return va.major - vb.major
+	case va.minor != vb.minor:
+		return va.minor - vb.minor
+	default:
+		return va.patch - vb.patch
+	}
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func mkTag(name string, daysAgo int, sizeMB int64) Tag {
+	return Tag{
+		Name:        name,
+		LastUpdated: time.Now().Add(-time.Duration(daysAgo) * 24 * time.Hour),
+		FullSize:    sizeMB * 1024 * 1024,
+	}
+}
+
+func TestFilterIncludeExcludeGlob(t *testing.T) {
+	f, err := NewFilter("release-*", "*-rc", "", "")
+	if err != nil {
+		t.Fatalf("NewFilter: %v", err)
+	}
+	tags := []Tag{mkTag("release-1.0", 0, 1), mkTag("release-1.0-rc", 0, 1), mkTag("nightly", 0, 1)}
+
+	got := f.Apply(tags)
+	if len(got) != 1 || got[0].Name != "release-1.0" {
+		t.Fatalf("expected only release-1.0 to survive, got %+v", got)
+	}
+}
+
+func TestFilterRegex(t *testing.T) {
+	f, err := NewFilter("", "", `^v\d+$`, "")
+	if err != nil {
+		t.Fatalf("NewFilter: %v", err)
+	}
+	tags := []Tag{mkTag("v1", 0, 1), mkTag("v1-debug", 0, 1)}
+
+	got := f.Apply(tags)
+	if len(got) != 1 || got[0].Name != "v1" {
+		t.Fatalf("expected only v1 to survive, got %+v", got)
+	}
+}
+
+func TestPolicyKeepCount(t *testing.T) {
+	p := &Policy{KeepCount: 1, SkipTags: map[string]struct{}{}}
+	tags := []Tag{mkTag("old", 5, 1), mkTag("new", 0, 1)}
+
+	decisions := p.Evaluate(tags)
+	for _, d := range decisions {
+		if d.Tag.Name == "new" && d.Delete {
+			t.Errorf("newest tag should be kept")
+		}
+		if d.Tag.Name == "old" && !d.Delete {
+			t.Errorf("oldest tag should be deleted")
+		}
+	}
+}
+
+func TestPolicySkipTagsProtectsFromCount(t *testing.T) {
+	p := &Policy{KeepCount: 0, SkipTags: map[string]struct{}{"protected": {}}}
+	tags := []Tag{mkTag("protected", 1, 1)}
+
+	decisions := p.Evaluate(tags)
+	if decisions[0].Delete {
+		t.Fatalf("protected tag should never be deleted, got %+v", decisions[0])
+	}
+}
+
+func TestPolicyKeepSemverLatestPerMajor(t *testing.T) {
+	p := &Policy{KeepCount: -1, KeepSemver: KeepSemverLatestMajor, SkipTags: map[string]struct{}{}}
+	tags := []Tag{
+		mkTag("v1.0.0", 10, 1),
+		mkTag("v1.2.0", 5, 1),
+		mkTag("v2.0.0", 3, 1),
+		mkTag("latest", 0, 1),
+	}
+
+	decisions := p.Evaluate(tags)
+	want := map[string]bool{
+		"v1.0.0": true,  // superseded by v1.2.0
+		"v1.2.0": false, // newest in major 1
+		"v2.0.0": false, // newest in major 2
+		"latest": false, // not semver, untouched by KEEP_SEMVER
+	}
+	for _, d := range decisions {
+		if d.Delete != want[d.Tag.Name] {
+			t.Errorf("tag %s: got Delete=%v, want %v (reason=%q)", d.Tag.Name, d.Delete, want[d.Tag.Name], d.Reason)
+		}
+	}
+}
+
+func TestPolicyKeepSemverLatestPerMinor(t *testing.T) {
+	p := &Policy{KeepCount: -1, KeepSemver: KeepSemverLatestMinor, SkipTags: map[string]struct{}{}}
+	tags := []Tag{
+		mkTag("v1.2.0", 10, 1),
+		mkTag("v1.2.3", 5, 1),
+		mkTag("v1.3.0", 3, 1),
+	}
+
+	decisions := p.Evaluate(tags)
+	for _, d := range decisions {
+		switch d.Tag.Name {
+		case "v1.2.3", "v1.3.0":
+			if d.Delete {
+				t.Errorf("expected %s to be kept, got deleted: %q", d.Tag.Name, d.Reason)
+			}
+		case "v1.2.0":
+			if !d.Delete {
+				t.Errorf("expected v1.2.0 to be superseded by v1.2.3")
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package progress is a minimal in-tree progress bar for long-running
+// cleanup runs, written to avoid pulling in an external dependency like
+// cheggaaa/pb for something this small.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bar renders "current/total processed, MB reclaimed, ETA" to an io.Writer
+// (stderr in normal use, so stdout stays a clean machine-parseable log of
+// deletions). It is safe for concurrent use.
+type Bar struct {
+	mu        sync.Mutex
+	total     int
+	done      int
+	reclaimed int64
+	startedAt time.Time
+	out       io.Writer
+}
+
+// New creates a Bar for a run of total tags, rendering to out.
+func New(total int, out io.Writer) *Bar {
+	return &Bar{total: total, startedAt: time.Now(), out: out}
+}
+
+// Advance records one more tag processed, having reclaimed reclaimedBytes
+// (0 for tags that were kept rather than deleted), and redraws the bar.
+func (b *Bar) Advance(reclaimedBytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done++
+	b.reclaimed += reclaimedBytes
+	b.render()
+}
+
+func (b *Bar) render() {
+	elapsed := time.Since(b.startedAt)
+	var eta time.Duration
+	if b.done > 0 && b.done < b.total {
+		eta = elapsed / time.Duration(b.done) * time.Duration(b.total-b.done)
+	}
+	fmt.Fprintf(b.out, "\r[%d/%d] %.2f MB reclaimed, ETA %s   ",
+		b.done, b.total, float64(b.reclaimed)/(1024*1024), eta.Round(time.Second))
+}
+
+// Finish redraws the bar one last time and moves to a fresh line, leaving a
+// clean final state whether the run completed or was aborted partway.
+func (b *Bar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.render()
+	fmt.Fprintln(b.out)
+}
@@ -0,0 +1,70 @@
+// Package ratelimit implements a small token-bucket rate limiter, used to
+// keep concurrent cleanup workers under a registry's request rate limit
+// (Docker Hub enforces one per account) instead of a heavier dependency.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter allows at most ratePerSec operations per second, refilled
+// continuously rather than in a single burst at the top of each second.
+// A nil *Limiter or one constructed with ratePerSec <= 0 never blocks.
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64
+	tokens   float64
+	capacity float64
+	last     time.Time
+}
+
+// New returns a Limiter allowing ratePerSec operations per second.
+func New(ratePerSec float64) *Limiter {
+	return &Limiter{rate: ratePerSec, tokens: ratePerSec, capacity: ratePerSec, last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done, then consumes a
+// token in the former case. A blocked caller (e.g. a worker throttled well
+// below the registry's own 429/5xx backoff) still notices cancellation
+// promptly instead of sleeping out its full wait.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0 {
+		return nil
+	}
+	for {
+		wait := l.tryTake()
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// tryTake refills the bucket based on elapsed time and, if a token is
+// available, consumes it and returns 0. Otherwise it returns how long the
+// caller should sleep before trying again.
+func (l *Limiter) tryTake() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowsBurstUpToCapacity(t *testing.T) {
+	l := New(10)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected the initial burst up to capacity to not block, took %s", elapsed)
+	}
+}
+
+func TestLimiterThrottlesBeyondCapacity(t *testing.T) {
+	l := New(100)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if err := l.Wait(ctx); err != nil { // 101st call must wait for a token to regenerate
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Fatalf("expected the 101st call to block briefly, took %s", elapsed)
+	}
+}
+
+func TestNilLimiterNeverBlocks(t *testing.T) {
+	var l *Limiter
+	if err := l.Wait(context.Background()); err != nil { // must not panic or block
+		t.Fatalf("Wait: %v", err)
+	}
+}
+
+func TestLimiterWaitRespectsContextCancellation(t *testing.T) {
+	l := New(1) // one token per second, so the second call must wait ~1s
+	ctx := context.Background()
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	start := time.Now()
+	if err := l.Wait(cancelCtx); err != context.Canceled {
+		t.Fatalf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected Wait to return promptly on a cancelled context, took %s", elapsed)
+	}
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TinyClayMan/DockerHubCleaner/policy"
+	"github.com/TinyClayMan/DockerHubCleaner/ratelimit"
+)
+
+const dockerHubAPI = "https://hub.docker.com/v2"
+
+// DockerHubClient talks to Docker Hub's proprietary Hub API: JWT login,
+// JSON tag listing with "next"-link pagination, and tag deletion by name.
+type DockerHubClient struct {
+	token string
+	http  *http.Client
+}
+
+// NewDockerHubClient returns a Client for hub.docker.com, throttled by
+// limiter (nil disables throttling).
+func NewDockerHubClient(limiter *ratelimit.Limiter) *DockerHubClient {
+	return &DockerHubClient{http: newHTTPClient(limiter)}
+}
+
+func (c *DockerHubClient) Login(ctx context.Context, user, password string) error {
+	data := url.Values{}
+	data.Set("username", user)
+	data.Set("password", password)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", dockerHubAPI+"/users/login/", strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login failed: %s", resp.Status)
+	}
+
+	var result map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	c.token = result["token"]
+	return nil
+}
+
+type hubTagsResponse struct {
+	Results []struct {
+		Name        string `json:"name"`
+		LastUpdated string `json:"last_updated"`
+		FullSize    int64  `json:"full_size"`
+	} `json:"results"`
+	Next string `json:"next"`
+}
+
+func (c *DockerHubClient) ListTags(ctx context.Context, repo string) ([]policy.Tag, error) {
+	var tags []policy.Tag
+	urlStr := fmt.Sprintf("%s/repositories/%s/tags?page_size=100", dockerHubAPI, repo)
+
+	for urlStr != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "JWT "+c.token)
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch tags: %s", resp.Status)
+		}
+
+		var tr hubTagsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+			return nil, err
+		}
+
+		for _, r := range tr.Results {
+			lastUpdated, _ := parseHubTime(r.LastUpdated)
+			tags = append(tags, policy.Tag{Name: r.Name, LastUpdated: lastUpdated, FullSize: r.FullSize})
+		}
+		urlStr = tr.Next
+	}
+	return tags, nil
+}
+
+// parseHubTime parses the RFC3339-with-nanoseconds timestamps Docker Hub
+// returns for last_updated.
+func parseHubTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func (c *DockerHubClient) DeleteTag(ctx context.Context, repo, tag string) error {
+	urlStr := fmt.Sprintf("%s/repositories/%s/tags/%s/", dockerHubAPI, repo, tag)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", urlStr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "JWT "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete %s: %s", tag, string(body))
+	}
+	return nil
+}
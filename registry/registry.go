@@ -0,0 +1,35 @@
+// Package registry abstracts over the HTTP APIs of the container registries
+// DockerHubCleaner can clean up: Docker Hub's proprietary Hub API, and the
+// standard OCI/Distribution v2 API spoken by Harbor, GHCR, GitLab, and
+// self-hosted registries.
+package registry
+
+import (
+	"context"
+
+	"github.com/TinyClayMan/DockerHubCleaner/policy"
+	"github.com/TinyClayMan/DockerHubCleaner/ratelimit"
+)
+
+// Client is implemented by each supported registry backend.
+type Client interface {
+	// Login authenticates against the registry. Implementations that defer
+	// authentication to a per-request challenge (the v2 Bearer flow) may
+	// simply record the credentials for later use.
+	Login(ctx context.Context, user, password string) error
+	// ListTags returns every tag in the given repository.
+	ListTags(ctx context.Context, repo string) ([]policy.Tag, error)
+	// DeleteTag removes a single tag from the given repository.
+	DeleteTag(ctx context.Context, repo, tag string) error
+}
+
+// New selects a Client implementation based on registryURL. An empty
+// registryURL (the default) selects Docker Hub; any other value is treated
+// as the base URL of a standard Distribution v2 registry. limiter throttles
+// every request the client makes; pass nil for no throttling.
+func New(registryURL string, limiter *ratelimit.Limiter) Client {
+	if registryURL == "" {
+		return NewDockerHubClient(limiter)
+	}
+	return NewV2Client(registryURL, limiter)
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TinyClayMan/DockerHubCleaner/ratelimit"
+)
+
+// defaultMaxRetries bounds how many times a request is retried on 429/5xx
+// before the caller sees the (failing) response.
+const defaultMaxRetries = 5
+
+// rateLimitedTransport wraps an http.RoundTripper with a token-bucket rate
+// limiter and automatic retry with exponential backoff on HTTP 429 and 5xx
+// responses, honoring Retry-After when the registry sends one. This is what
+// lets a CONCURRENCY>1 worker pool run against Docker Hub without tripping
+// its per-account rate limits.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *ratelimit.Limiter
+}
+
+// newHTTPClient returns an *http.Client whose requests are throttled by
+// limiter and automatically retried on 429/5xx. A nil limiter disables
+// throttling but retries still apply.
+func newHTTPClient(limiter *ratelimit.Limiter) *http.Client {
+	return &http.Client{Transport: &rateLimitedTransport{base: http.DefaultTransport, limiter: limiter}}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := 500 * time.Millisecond
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt <= defaultMaxRetries; attempt++ {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err = t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt == defaultMaxRetries {
+			return resp, nil
+		}
+
+		wait := backoff
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+			wait = retryAfter
+		}
+		resp.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+	return resp, err
+}
+
+// parseRetryAfter understands the Retry-After header's delay-seconds form
+// (the HTTP-date form isn't used by any registry we target).
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	secs, err := strconv.Atoi(header)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// onceReader wraps a reader that can only be read through once, like an
+// HTTP response body, to make sure a retry never reuses a drained stream.
+type onceReader struct {
+	io.Reader
+}
+
+func (onceReader) Seek(offset int64, whence int) (int64, error) {
+	panic("onceReader is not seekable")
+}
+
+// TestRateLimitedTransportRetriesWithGetBody forces a 500-then-201 retry on
+// a request whose body comes from a non-seekable reader (so
+// http.NewRequestWithContext can't populate GetBody on its own), gives the
+// request an explicit GetBody, and checks that the retried attempt resends
+// the full body and the caller only sees the successful response.
+func TestRateLimitedTransportRetriesWithGetBody(t *testing.T) {
+	want := []byte("blob content that must survive a retry intact")
+
+	var attempts int
+	var gotBodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("reading request body: %v", err)
+		}
+		gotBodies = append(gotBodies, body)
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	transport := &rateLimitedTransport{base: http.DefaultTransport, limiter: nil}
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, onceReader{bytes.NewReader(want)})
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.ContentLength = int64(len(want))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(want)), nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("final status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	for i, body := range gotBodies {
+		if !bytes.Equal(body, want) {
+			t.Errorf("attempt %d body = %q, want %q (truncated/corrupted retry)", i+1, body, want)
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TinyClayMan/DockerHubCleaner/policy"
+	"github.com/TinyClayMan/DockerHubCleaner/ratelimit"
+)
+
+// V2Client talks to any registry implementing the standard OCI/Distribution
+// v2 HTTP API (Harbor, GHCR, GitLab, or a self-hosted registry), including
+// its Bearer token challenge/response authentication flow.
+type V2Client struct {
+	baseURL  string
+	user     string
+	password string
+	http     *http.Client
+
+	tokenMu sync.Mutex
+	tokens  map[string]cachedToken
+}
+
+// cachedToken is a bearer token exchanged for a given scope, plus when it
+// stops being worth reusing. expiresAt is the zero Time only for the empty
+// token of a registry that doesn't challenge at all, which never expires; a
+// token whose response gave no lifetime is cached for defaultTokenTTL.
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// defaultTokenTTL bounds how long a token is cached when the registry's
+// token response omits expires_in.
+const defaultTokenTTL = 60 * time.Second
+
+// NewV2Client returns a Client for the v2 registry at baseURL, e.g.
+// "https://ghcr.io" or "https://harbor.example.com", throttled by limiter
+// (nil disables throttling).
+func NewV2Client(baseURL string, limiter *ratelimit.Limiter) *V2Client {
+	return &V2Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    newHTTPClient(limiter),
+		tokens:  make(map[string]cachedToken),
+	}
+}
+
+// Login only records the credentials: the v2 Bearer flow re-authenticates
+// per request scope, so there is nothing to do eagerly.
+func (c *V2Client) Login(ctx context.Context, user, password string) error {
+	c.user = user
+	c.password = password
+	return nil
+}
+
+// bearerChallenge is the parsed form of a WWW-Authenticate: Bearer header.
+type bearerChallenge struct {
+	realm   string
+	service string
+}
+
+var bearerParamRE = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func parseBearerChallenge(header string) (*bearerChallenge, error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("unsupported WWW-Authenticate scheme: %s", header)
+	}
+	bc := &bearerChallenge{}
+	for _, m := range bearerParamRE.FindAllStringSubmatch(header, -1) {
+		switch m[1] {
+		case "realm":
+			bc.realm = m[2]
+		case "service":
+			bc.service = m[2]
+		}
+	}
+	if bc.realm == "" {
+		return nil, fmt.Errorf("missing realm in WWW-Authenticate header: %s", header)
+	}
+	return bc, nil
+}
+
+// token returns a Bearer token for scope, reusing a cached one while it
+// remains valid. Otherwise it probes GET /v2/ for the registry's auth
+// challenge and exchanges it at the challenge's realm using HTTP Basic
+// auth, exactly as the standard v2 token flow works. A registry that
+// doesn't challenge at all (StatusOK) returns an empty token, meaning the
+// request can proceed unauthenticated; that's cached too, so the probe
+// itself isn't repeated on every call.
+func (c *V2Client) token(ctx context.Context, scope string) (string, error) {
+	if tok, ok := c.cachedToken(scope); ok {
+		return tok, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/v2/", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		c.storeToken(scope, "", time.Time{})
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", fmt.Errorf("unexpected status probing %s/v2/: %s", c.baseURL, resp.Status)
+	}
+
+	challenge, err := parseBearerChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return "", err
+	}
+
+	tokenURL, err := url.Parse(challenge.realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid realm %q: %w", challenge.realm, err)
+	}
+	q := tokenURL.Query()
+	if challenge.service != "" {
+		q.Set("service", challenge.service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	tokenURL.RawQuery = q.Encode()
+
+	tokReq, err := http.NewRequestWithContext(ctx, "GET", tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.user != "" {
+		tokReq.SetBasicAuth(c.user, c.password)
+	}
+
+	tokResp, err := c.http.Do(tokReq)
+	if err != nil {
+		return "", err
+	}
+	defer tokResp.Body.Close()
+
+	if tokResp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(tokResp.Body)
+		return "", fmt.Errorf("token request to %s failed: %s: %s", challenge.realm, tokResp.Status, body)
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		IssuedAt    string `json:"issued_at"`
+	}
+	if err := json.NewDecoder(tokResp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	tok := result.Token
+	if tok == "" {
+		tok = result.AccessToken
+	}
+	c.storeToken(scope, tok, tokenExpiry(result.IssuedAt, result.ExpiresIn))
+	return tok, nil
+}
+
+// tokenExpiry turns a token response's issued_at/expires_in into an
+// absolute expiry. A missing expires_in falls back to defaultTokenTTL from
+// now; a missing or unparseable issued_at counts as issued now.
+func tokenExpiry(issuedAt string, expiresIn int) time.Time {
+	if expiresIn <= 0 {
+		return time.Now().Add(defaultTokenTTL)
+	}
+	issued := time.Now()
+	if issuedAt != "" {
+		if t, err := time.Parse(time.RFC3339, issuedAt); err == nil {
+			issued = t
+		}
+	}
+	return issued.Add(time.Duration(expiresIn) * time.Second)
+}
+
+// cachedToken returns a still-valid cached token for scope, if any.
+func (c *V2Client) cachedToken(scope string) (string, bool) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	ct, ok := c.tokens[scope]
+	if !ok {
+		return "", false
+	}
+	if !ct.expiresAt.IsZero() && !time.Now().Before(ct.expiresAt) {
+		return "", false
+	}
+	return ct.token, true
+}
+
+func (c *V2Client) storeToken(scope, token string, expiresAt time.Time) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.tokens[scope] = cachedToken{token: token, expiresAt: expiresAt}
+}
+
+// invalidateToken drops scope's cached token, so the next call to token()
+// re-authenticates instead of reusing one the registry just rejected.
+func (c *V2Client) invalidateToken(scope string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	delete(c.tokens, scope)
+}
+
+// do attaches a Bearer token scoped to repo/actions to req and sends it,
+// retrying once with a freshly exchanged token if the cached one is
+// rejected with 401 (the registry may have revoked it or disagreed with our
+// cached expiry).
+func (c *V2Client) do(ctx context.Context, req *http.Request, repo, actions string) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:%s", repo, actions)
+
+	if err := c.attachToken(ctx, req, scope); err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	resp.Body.Close()
+	c.invalidateToken(scope)
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	if err := c.attachToken(ctx, req, scope); err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+// attachToken sets req's Authorization header to a Bearer token for scope,
+// if the registry requires one.
+func (c *V2Client) attachToken(ctx context.Context, req *http.Request, scope string) error {
+	tok, err := c.token(ctx, scope)
+	if err != nil {
+		return err
+	}
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return nil
+}
+
+type v2TagsList struct {
+	Tags []string `json:"tags"`
+}
+
+// nextPageURL extracts the next page URL from a Link header of the form
+// `<url>; rel="next"`, as returned by the Distribution v2 tags/list endpoint.
+var linkNextRE = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+func (c *V2Client) nextPageURL(linkHeader string) string {
+	m := linkNextRE.FindStringSubmatch(linkHeader)
+	if m == nil {
+		return ""
+	}
+	if strings.HasPrefix(m[1], "http") {
+		return m[1]
+	}
+	return c.baseURL + m[1]
+}
+
+// ListTags fetches every tag name via GET /v2/<repo>/tags/list, following
+// Link-header pagination. The v2 spec doesn't return size or last-modified
+// metadata alongside tag names, so LastUpdated/FullSize are left zero:
+// MAX_SIZE_MB never deletes anything (every tag counts as size 0), and
+// KEEP_COUNT has no age to order tags by.
+func (c *V2Client) ListTags(ctx context.Context, repo string) ([]policy.Tag, error) {
+	var names []string
+	next := fmt.Sprintf("%s/v2/%s/tags/list", c.baseURL, repo)
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ctx, req, repo, "pull")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("failed to list tags for %s: %s: %s", repo, resp.Status, body)
+		}
+
+		var tl v2TagsList
+		if err := json.NewDecoder(resp.Body).Decode(&tl); err != nil {
+			return nil, err
+		}
+		names = append(names, tl.Tags...)
+		next = c.nextPageURL(resp.Header.Get("Link"))
+	}
+
+	tags := make([]policy.Tag, len(names))
+	for i, name := range names {
+		tags[i] = policy.Tag{Name: name}
+	}
+	return tags, nil
+}
+
+var manifestAccept = strings.Join([]string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}, ", ")
+
+// digest resolves a tag to its content digest via HEAD, as required before
+// the v2 delete-by-digest endpoint can be used (tags cannot be deleted
+// directly).
+func (c *V2Client) digest(ctx context.Context, repo, tag string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, tag), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", manifestAccept)
+
+	resp, err := c.do(ctx, req, repo, "pull")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to resolve digest for %s:%s: %s", repo, tag, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return Docker-Content-Digest for %s:%s", repo, tag)
+	}
+	return digest, nil
+}
+
+// DeleteTag resolves tag to its manifest digest, then issues
+// DELETE /v2/<repo>/manifests/<digest>.
+func (c *V2Client) DeleteTag(ctx context.Context, repo, tag string) error {
+	digest, err := c.digest(ctx, repo, tag)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, req, repo, "pull,delete")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete %s:%s: %s: %s", repo, tag, resp.Status, body)
+	}
+	return nil
+}
+
+// GetManifest fetches the raw manifest (or manifest list / OCI index) body
+// for repo:ref, along with its Content-Type, via
+// GET /v2/<repo>/manifests/<ref>. ref may be a tag or a digest.
+func (c *V2Client) GetManifest(ctx context.Context, repo, ref string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, ref), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", manifestAccept)
+
+	resp, err := c.do(ctx, req, repo, "pull")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("failed to fetch manifest %s:%s: %s: %s", repo, ref, resp.Status, body)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// PutManifest uploads body as repo:ref's manifest via
+// PUT /v2/<repo>/manifests/<ref>.
+func (c *V2Client) PutManifest(ctx context.Context, repo, ref, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, ref), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.do(ctx, req, repo, "pull,push")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to push manifest %s:%s: %s: %s", repo, ref, resp.Status, respBody)
+	}
+	return nil
+}
+
+// HasBlob reports whether digest already exists in repo, via
+// HEAD /v2/<repo>/blobs/<digest>.
+func (c *V2Client) HasBlob(ctx context.Context, repo, digest string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ctx, req, repo, "pull")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status checking blob %s in %s: %s", digest, repo, resp.Status)
+	}
+}
+
+// GetBlob streams digest's content from repo via GET /v2/<repo>/blobs/<digest>.
+// The caller must close the returned ReadCloser.
+func (c *V2Client) GetBlob(ctx context.Context, repo, digest string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.do(ctx, req, repo, "pull")
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("failed to fetch blob %s from %s: %s: %s", digest, repo, resp.Status, body)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// MountBlob attempts a cross-repository mount of digest from fromRepo into
+// repo via POST /v2/<repo>/blobs/uploads/?mount=<digest>&from=<fromRepo>,
+// the cheap path that avoids re-uploading a blob the registry already has
+// under a different repository. It reports false (not an error) if the
+// registry didn't mount it, so the caller can fall back to streaming it.
+func (c *V2Client) MountBlob(ctx context.Context, repo, digest, fromRepo string) (bool, error) {
+	u := fmt.Sprintf("%s/v2/%s/blobs/uploads/?mount=%s&from=%s", c.baseURL, repo, url.QueryEscape(digest), url.QueryEscape(fromRepo))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ctx, req, repo, "pull,push")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// PushBlob uploads digest's content to repo via the chunked upload
+// endpoints: POST to start the session, then PUT the body and digest to
+// complete it in a single round trip.
+//
+// r is typically a live network stream from the source registry (GetBlob),
+// which isn't one of the body types net/http can rewind on its own. The
+// rate-limited transport retries 429/5xx responses, and replaying an
+// already-drained stream would silently upload a truncated blob, so the
+// blob is spooled to a temp file first and the PUT request's GetBody reopens
+// that file, making the upload safely retryable.
+func (c *V2Client) PushBlob(ctx context.Context, repo, digest string, r io.Reader, size int64) error {
+	spoolPath, spooledSize, err := spoolToTempFile(r)
+	if err != nil {
+		return fmt.Errorf("failed to buffer blob %s for upload: %w", digest, err)
+	}
+	defer os.Remove(spoolPath)
+	if size <= 0 {
+		size = spooledSize
+	}
+
+	startReq, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v2/%s/blobs/uploads/", c.baseURL, repo), nil)
+	if err != nil {
+		return err
+	}
+	startResp, err := c.do(ctx, startReq, repo, "pull,push")
+	if err != nil {
+		return err
+	}
+	startResp.Body.Close()
+	if startResp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start blob upload for %s in %s: %s", digest, repo, startResp.Status)
+	}
+
+	uploadURL, err := startResp.Location()
+	if err != nil {
+		return fmt.Errorf("registry did not return an upload Location for %s: %w", repo, err)
+	}
+	q := uploadURL.Query()
+	q.Set("digest", digest)
+	uploadURL.RawQuery = q.Encode()
+
+	putReq, err := http.NewRequestWithContext(ctx, "PUT", uploadURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	putReq.ContentLength = size
+	putReq.GetBody = func() (io.ReadCloser, error) { return os.Open(spoolPath) }
+	putReq.Body, err = putReq.GetBody()
+	if err != nil {
+		return fmt.Errorf("failed to open spooled blob %s: %w", digest, err)
+	}
+	putReq.Header.Set("Content-Type", "application/octet-stream")
+
+	putResp, err := c.do(ctx, putReq, repo, "pull,push")
+	if err != nil {
+		return err
+	}
+	defer putResp.Body.Close()
+
+	if putResp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(putResp.Body)
+		return fmt.Errorf("failed to complete blob upload for %s in %s: %s: %s", digest, repo, putResp.Status, body)
+	}
+	return nil
+}
+
+// spoolToTempFile copies r to a temp file and returns its path and size, so
+// callers can hand off a rewindable body instead of a single-use stream.
+func spoolToTempFile(r io.Reader) (path string, size int64, err error) {
+	f, err := ioutil.TempFile("", "dockerhubcleaner-blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	header := `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo/bar:pull"`
+
+	got, err := parseBearerChallenge(header)
+	if err != nil {
+		t.Fatalf("parseBearerChallenge: %v", err)
+	}
+	if got.realm != "https://auth.example.com/token" {
+		t.Errorf("realm = %q", got.realm)
+	}
+	if got.service != "registry.example.com" {
+		t.Errorf("service = %q", got.service)
+	}
+}
+
+func TestParseBearerChallengeRejectsBasic(t *testing.T) {
+	if _, err := parseBearerChallenge(`Basic realm="example"`); err == nil {
+		t.Fatal("expected error for non-Bearer challenge")
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	c := NewV2Client("https://registry.example.com", nil)
+
+	got := c.nextPageURL(`</v2/foo/tags/list?last=bar&n=100>; rel="next"`)
+	want := "https://registry.example.com/v2/foo/tags/list?last=bar&n=100"
+	if got != want {
+		t.Errorf("nextPageURL() = %q, want %q", got, want)
+	}
+
+	if got := c.nextPageURL(""); got != "" {
+		t.Errorf("nextPageURL() on empty header = %q, want empty", got)
+	}
+}
+
+func TestV2ClientCachesToken(t *testing.T) {
+	var probes, exchanges int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			probes++
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, "http://"+r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			exchanges++
+			fmt.Fprint(w, `{"token":"tok1"}`)
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	c := NewV2Client(server.URL, nil)
+	ctx := context.Background()
+	const scope = "repository:foo/bar:pull"
+
+	for i := 0; i < 3; i++ {
+		tok, err := c.token(ctx, scope)
+		if err != nil {
+			t.Fatalf("token() call %d: %v", i, err)
+		}
+		if tok != "tok1" {
+			t.Errorf("token() call %d = %q, want %q", i, tok, "tok1")
+		}
+	}
+	if probes != 1 || exchanges != 1 {
+		t.Errorf("probes=%d exchanges=%d, want 1 and 1 (token should be cached across calls)", probes, exchanges)
+	}
+
+	c.invalidateToken(scope)
+	if _, err := c.token(ctx, scope); err != nil {
+		t.Fatalf("token() after invalidate: %v", err)
+	}
+	if probes != 2 || exchanges != 2 {
+		t.Errorf("probes=%d exchanges=%d after invalidate, want 2 and 2", probes, exchanges)
+	}
+}